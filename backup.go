@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// DefaultBackupFormat is used when -backup-format is not passed.
+const DefaultBackupFormat = "tar.zst"
+
+// archiveCodec can both write and read a given archive format; every format
+// offered through -backup-format/-restore implements it.
+type archiveCodec interface {
+	archiver.Archiver
+	archiver.Extractor
+}
+
+// codecForFormat maps a -backup-format value to its archiver codec and the
+// file extension the resulting backup artifact should use.
+func codecForFormat(format string) (archiveCodec, string, error) {
+	switch format {
+	case "tar.zst":
+		return archiver.Archive{Compression: archiver.Zstd{}, Archival: archiver.Tar{}, Extraction: archiver.Tar{}}, "tar.zst", nil
+	case "tar.gz":
+		return archiver.Archive{Compression: archiver.Gz{}, Archival: archiver.Tar{}, Extraction: archiver.Tar{}}, "tar.gz", nil
+	case "zip":
+		return archiver.Zip{}, "zip", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported backup format %q (expected tar.zst, tar.gz, or zip)", format)
+	}
+}
+
+// backupAllFiles backs up filesToProcess before any file is mutated. When
+// format is "dir" it preserves the historical loose-.bak-tree behavior;
+// otherwise it writes a single timestamped archive under backupDir that
+// mirrors the original directory structure, e.g.
+// backup/fixmd-20250101-153000.tar.zst.
+func backupAllFiles(filesToProcess []FileToProcess, backupDir string, format string) error {
+	if format == "dir" {
+		return backupAllFilesLoose(filesToProcess, backupDir)
+	}
+
+	codec, ext, err := codecForFormat(format)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %v", err)
+	}
+
+	archivePath := filepath.Join(backupDir, fmt.Sprintf("fixmd-%s.%s", time.Now().Format("20060102-150405"), ext))
+
+	filesOnDisk := make(map[string]string, len(filesToProcess))
+	for _, fileInfo := range filesToProcess {
+		filesOnDisk[fileInfo.Path] = fileInfo.RelPath
+	}
+
+	files, err := archiver.FilesFromDisk(nil, filesOnDisk)
+	if err != nil {
+		return fmt.Errorf("error preparing files for archive: %v", err)
+	}
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("error creating archive file: %v", err)
+	}
+	defer out.Close()
+
+	if err := codec.Archive(context.Background(), out, files); err != nil {
+		return fmt.Errorf("error writing archive: %v", err)
+	}
+
+	fmt.Printf("All files successfully backed up to %s\n", archivePath)
+	return nil
+}
+
+// backupAllFilesLoose recreates the original per-file .bak tree under
+// backupDir, kept around for users who prefer a plain, diffable shadow copy
+// over a single archive.
+func backupAllFilesLoose(filesToProcess []FileToProcess, backupDir string) error {
+	for i, fileInfo := range filesToProcess {
+		backupFilePath := filepath.Join(backupDir, fileInfo.RelPath+".bak")
+
+		if err := os.MkdirAll(filepath.Dir(backupFilePath), 0755); err != nil {
+			return fmt.Errorf("error creating backup directory structure for %s: %v", fileInfo.Path, err)
+		}
+
+		if err := os.WriteFile(backupFilePath, fileInfo.Content, 0644); err != nil {
+			return fmt.Errorf("error creating backup file for %s: %v", fileInfo.Path, err)
+		}
+
+		fmt.Printf("\r[%d/%d] Backup created: %s", i+1, len(filesToProcess), fileInfo.Path)
+	}
+
+	fmt.Println("\nAll files successfully backed up.")
+	return nil
+}
+
+// restoreBackup expands archivePath back over the working tree, overwriting
+// any files it contains. It supports the same tar.zst/tar.gz/zip formats
+// backupAllFiles can produce, detected from the archive's extension.
+func restoreBackup(archivePath string) error {
+	format, ok := formatFromExtension(archivePath)
+	if !ok {
+		return fmt.Errorf("cannot determine backup format from %q (expected .tar.zst, .tar.gz, or .zip)", archivePath)
+	}
+
+	codec, _, err := codecForFormat(format)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %v", err)
+	}
+	defer in.Close()
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting current directory: %v", err)
+	}
+
+	handler := func(ctx context.Context, f archiver.FileInfo) error {
+		destPath := filepath.Join(workDir, f.NameInArchive)
+
+		if f.IsDir() {
+			return os.MkdirAll(destPath, 0755)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return fmt.Errorf("error creating directory for %s: %v", destPath, err)
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("error opening %s in archive: %v", f.NameInArchive, err)
+		}
+		defer src.Close()
+
+		dest, err := os.OpenFile(destPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", destPath, err)
+		}
+		defer dest.Close()
+
+		if _, err := io.Copy(dest, src); err != nil {
+			return fmt.Errorf("error restoring %s: %v", destPath, err)
+		}
+
+		fmt.Printf("Restored: %s\n", f.NameInArchive)
+		return nil
+	}
+
+	if err := codec.Extract(context.Background(), in, handler); err != nil {
+		return fmt.Errorf("error extracting archive: %v", err)
+	}
+
+	return nil
+}
+
+func formatFromExtension(path string) (string, bool) {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".tar.zst"):
+		return "tar.zst", true
+	case strings.HasSuffix(lower, ".tar.gz"):
+		return "tar.gz", true
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", true
+	default:
+		return "", false
+	}
+}
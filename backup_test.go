@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBackupRestoreRoundTrip exercises backupAllFiles -> restoreBackup for
+// every archive format codecForFormat knows about, guarding against
+// regressions like a codec that can Archive but not Extract (see
+// codecForFormat's Extraction field).
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	for _, format := range []string{"tar.zst", "tar.gz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			srcDir := t.TempDir()
+
+			files := []FileToProcess{
+				{RelPath: "a.md", Content: []byte("# A\n")},
+				{RelPath: filepath.Join("sub", "b.md"), Content: []byte("# B\n")},
+			}
+			for i := range files {
+				abs := filepath.Join(srcDir, files[i].RelPath)
+				if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+					t.Fatalf("MkdirAll: %v", err)
+				}
+				if err := os.WriteFile(abs, files[i].Content, 0644); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+				files[i].Path = abs
+			}
+
+			backupDir := filepath.Join(srcDir, "backup")
+			if err := backupAllFiles(files, backupDir, format); err != nil {
+				t.Fatalf("backupAllFiles(%s): %v", format, err)
+			}
+
+			entries, err := os.ReadDir(backupDir)
+			if err != nil {
+				t.Fatalf("ReadDir backup: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected exactly one archive in %s, found %d", backupDir, len(entries))
+			}
+			archivePath := filepath.Join(backupDir, entries[0].Name())
+
+			destDir := t.TempDir()
+
+			origWD, err := os.Getwd()
+			if err != nil {
+				t.Fatalf("Getwd: %v", err)
+			}
+			if err := os.Chdir(destDir); err != nil {
+				t.Fatalf("Chdir: %v", err)
+			}
+			defer os.Chdir(origWD)
+
+			if err := restoreBackup(archivePath); err != nil {
+				t.Fatalf("restoreBackup(%s): %v", format, err)
+			}
+
+			for _, f := range files {
+				got, err := os.ReadFile(filepath.Join(destDir, f.RelPath))
+				if err != nil {
+					t.Fatalf("restored file %s missing: %v", f.RelPath, err)
+				}
+				if string(got) != string(f.Content) {
+					t.Errorf("restored %s = %q, want %q", f.RelPath, got, f.Content)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultCacheFile is where the content-addressed cache lives, alongside
+// the markdown tree being processed.
+const DefaultCacheFile = ".fixmd-cache.json"
+
+// Cache is a content-addressed store of already-formatted markdown, keyed
+// by sha256(provider identity | prompt version | content). A file whose
+// current content hashes to an entry whose cached output equals that same
+// content is already at its formatted fixed point, so processFileContent
+// can skip the API call entirely - making "fixmd -r ." nearly free to
+// re-run over an unchanged tree.
+type Cache struct {
+	path    string
+	refresh bool
+
+	mu      sync.Mutex
+	entries map[string]string
+	dirty   bool
+}
+
+// LoadCache reads path if it exists, or starts empty if it doesn't. When
+// refresh is true, Get always reports a miss (as -refresh-cache wants)
+// while Set still records entries so the cache is brought up to date.
+func LoadCache(path string, refresh bool) (*Cache, error) {
+	c := &Cache{path: path, refresh: refresh, entries: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("error parsing cache file: %v", err)
+	}
+
+	return c, nil
+}
+
+// Key derives the cache key for content processed by a given provider
+// identity and prompt version.
+func (c *Cache) Key(providerIdentity, promptVersion, content string) string {
+	sum := sha256.Sum256([]byte(providerIdentity + "|" + promptVersion + "|" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached output for key, if any.
+func (c *Cache) Get(key string) (string, bool) {
+	if c.refresh {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output, ok := c.entries[key]
+	return output, ok
+}
+
+// Set records output for key.
+func (c *Cache) Set(key, output string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == output {
+		return
+	}
+	c.entries[key] = output
+	c.dirty = true
+}
+
+// Save writes the cache back to disk if anything changed since it was
+// loaded.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling cache: %v", err)
+	}
+
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing cache file: %v", err)
+	}
+
+	c.dirty = false
+	return nil
+}
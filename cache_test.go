@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKeyIsDeterministicAndContentSensitive(t *testing.T) {
+	c := &Cache{entries: make(map[string]string)}
+
+	k1 := c.Key("gemini:gemini-pro", "v1", "hello")
+	k2 := c.Key("gemini:gemini-pro", "v1", "hello")
+	if k1 != k2 {
+		t.Fatalf("Key should be deterministic for identical inputs, got %q vs %q", k1, k2)
+	}
+
+	if k3 := c.Key("gemini:gemini-pro", "v1", "goodbye"); k3 == k1 {
+		t.Errorf("Key should differ when content differs")
+	}
+	if k4 := c.Key("openai:gpt-4", "v1", "hello"); k4 == k1 {
+		t.Errorf("Key should differ when provider identity differs")
+	}
+	if k5 := c.Key("gemini:gemini-pro", "v2", "hello"); k5 == k1 {
+		t.Errorf("Key should differ when prompt version differs")
+	}
+}
+
+func TestCacheGetSetHitMiss(t *testing.T) {
+	c := &Cache{entries: make(map[string]string)}
+	key := c.Key("gemini:gemini-pro", "v1", "hello")
+
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("expected miss before any Set")
+	}
+
+	c.Set(key, "HELLO")
+
+	got, ok := c.Get(key)
+	if !ok {
+		t.Fatalf("expected hit after Set")
+	}
+	if got != "HELLO" {
+		t.Errorf("got %q, want %q", got, "HELLO")
+	}
+}
+
+func TestCacheRefreshAlwaysMisses(t *testing.T) {
+	c := &Cache{entries: make(map[string]string), refresh: true}
+	key := c.Key("gemini:gemini-pro", "v1", "hello")
+	c.Set(key, "HELLO")
+
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("expected Get to always miss when refresh is set")
+	}
+}
+
+func TestCacheSetIsIdempotentWithoutDirtying(t *testing.T) {
+	c := &Cache{entries: make(map[string]string)}
+	key := c.Key("gemini:gemini-pro", "v1", "hello")
+
+	c.Set(key, "HELLO")
+	c.dirty = false
+
+	c.Set(key, "HELLO")
+	if c.dirty {
+		t.Errorf("Set should not mark the cache dirty when the value is unchanged")
+	}
+}
+
+func TestLoadCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, DefaultCacheFile)
+
+	c, err := LoadCache(path, false)
+	if err != nil {
+		t.Fatalf("LoadCache on missing file: %v", err)
+	}
+
+	key := c.Key("gemini:gemini-pro", "v1", "hello")
+	c.Set(key, "HELLO")
+
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected cache file to exist after Save: %v", err)
+	}
+
+	reloaded, err := LoadCache(path, false)
+	if err != nil {
+		t.Fatalf("LoadCache on existing file: %v", err)
+	}
+
+	got, ok := reloaded.Get(key)
+	if !ok || got != "HELLO" {
+		t.Fatalf("expected reloaded cache to contain the saved entry, got %q, %v", got, ok)
+	}
+}
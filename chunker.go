@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// mdChunk is one independently-processable section of a markdown document:
+// either the leading frontmatter/preamble, or a top-level ("# ") ATX
+// heading together with everything up to (but not including) the next one.
+type mdChunk struct {
+	Content string
+}
+
+var atxH1Re = regexp.MustCompile(`^#\s+\S`)
+
+// chunkMarkdown splits content on top-level ATX headings so large files can
+// be sent to the LLM a section at a time instead of in one shot. Frontmatter,
+// fenced code blocks (``` or ~~~), and HTML blocks are tracked line by line
+// so a heading that appears inside any of them is never treated as a split
+// point - a heading is only ever split away from its own body.
+func chunkMarkdown(content string) []mdChunk {
+	lines := strings.Split(content, "\n")
+
+	var chunks []mdChunk
+	var current []string
+
+	inFrontmatter := false
+	frontmatterDelim := ""
+	inFence := false
+	fenceDelim := ""
+	inHTMLBlock := false
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		chunks = append(chunks, mdChunk{Content: strings.Join(current, "\n")})
+		current = nil
+	}
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if i == 0 && (trimmed == "---" || trimmed == "+++") {
+			inFrontmatter = true
+			frontmatterDelim = trimmed
+			current = append(current, line)
+			continue
+		}
+		if inFrontmatter {
+			current = append(current, line)
+			if trimmed == frontmatterDelim {
+				inFrontmatter = false
+			}
+			continue
+		}
+
+		if inFence {
+			current = append(current, line)
+			if strings.HasPrefix(trimmed, fenceDelim) {
+				inFence = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = true
+			fenceDelim = trimmed[:3]
+			current = append(current, line)
+			continue
+		}
+
+		if inHTMLBlock {
+			current = append(current, line)
+			if trimmed == "" {
+				inHTMLBlock = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "<") && !strings.HasPrefix(trimmed, "<!--") {
+			inHTMLBlock = true
+			current = append(current, line)
+			continue
+		}
+
+		if atxH1Re.MatchString(line) && len(current) > 0 {
+			flush()
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return chunks
+}
+
+// formatMarkdown runs content through provider one heading-aware chunk at a
+// time, re-concatenating the results in their original order. Each chunk
+// acquires sem exactly like a whole-file request used to, so total LLM
+// concurrency is unchanged by chunking. A chunk whose processed output
+// fails the structural invariant check (see fingerprintChunk) is left
+// untouched in the output and reported as a warning through ui instead of
+// silently losing content.
+func formatMarkdown(ctx context.Context, provider Provider, sem *ApiSemaphore, ui *UI, path string, content string) (string, error) {
+	chunks := chunkMarkdown(content)
+
+	results := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk mdChunk) {
+			defer wg.Done()
+
+			sem.Acquire()
+			defer sem.Release()
+
+			out, err := provider.Format(ctx, chunk.Content)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			if fingerprintChunk(chunk.Content).Equal(fingerprintChunk(out)) {
+				results[i] = out
+				return
+			}
+
+			results[i] = chunk.Content
+			if ui != nil {
+				ui.Message("warn", "%s: chunk %d/%d changed code blocks, links, or headings - keeping original", path, i+1, len(chunks))
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return "", fmt.Errorf("error processing chunk: %v", err)
+		}
+	}
+
+	return strings.Join(results, "\n"), nil
+}
+
+// chunkFingerprint captures the parts of a chunk that must round-trip
+// unchanged through the model: code-block contents, link/reference URLs,
+// and heading anchors.
+type chunkFingerprint struct {
+	codeBlocks []string
+	linkURLs   []string
+	anchors    []string
+}
+
+var (
+	mdLinkRe    = regexp.MustCompile(`\[[^\]]*\]\(([^)]+)\)`)
+	mdRefDefRe  = regexp.MustCompile(`^\[[^\]]+\]:\s*(\S+)`)
+	mdHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.+)$`)
+)
+
+// fingerprintChunk is a minimal CommonMark tokenizer: just enough structure
+// to detect a model silently dropping or rewriting content it was told not
+// to touch, without fully parsing markdown.
+func fingerprintChunk(content string) chunkFingerprint {
+	var fp chunkFingerprint
+
+	inFence := false
+	fenceDelim := ""
+	var fenceBuf []string
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if inFence {
+			if strings.HasPrefix(trimmed, fenceDelim) {
+				inFence = false
+				fp.codeBlocks = append(fp.codeBlocks, strings.Join(fenceBuf, "\n"))
+				fenceBuf = nil
+				continue
+			}
+			fenceBuf = append(fenceBuf, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = true
+			fenceDelim = trimmed[:3]
+			continue
+		}
+
+		for _, m := range mdLinkRe.FindAllStringSubmatch(line, -1) {
+			fp.linkURLs = append(fp.linkURLs, m[1])
+		}
+		if m := mdRefDefRe.FindStringSubmatch(trimmed); m != nil {
+			fp.linkURLs = append(fp.linkURLs, m[1])
+		}
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			fp.anchors = append(fp.anchors, slugifyHeading(m[2]))
+		}
+	}
+	// An unterminated fence still counts its (partial) contents so a model
+	// that closes a fence we left open is caught as a mismatch.
+	if inFence && len(fenceBuf) > 0 {
+		fp.codeBlocks = append(fp.codeBlocks, strings.Join(fenceBuf, "\n"))
+	}
+
+	return fp
+}
+
+func slugifyHeading(heading string) string {
+	heading = strings.ToLower(strings.TrimSpace(heading))
+
+	var b strings.Builder
+	lastDash := false
+	for _, r := range heading {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			b.WriteRune(r)
+			lastDash = false
+		case r == ' ' || r == '-' || r == '_':
+			if !lastDash {
+				b.WriteByte('-')
+				lastDash = true
+			}
+		}
+	}
+
+	return strings.Trim(b.String(), "-")
+}
+
+// Equal reports whether two fingerprints contain the same code blocks,
+// link URLs, and heading anchors, ignoring order.
+func (fp chunkFingerprint) Equal(other chunkFingerprint) bool {
+	return stringMultisetEqual(fp.codeBlocks, other.codeBlocks) &&
+		stringMultisetEqual(fp.linkURLs, other.linkURLs) &&
+		stringMultisetEqual(fp.anchors, other.anchors)
+}
+
+func stringMultisetEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+	}
+	for _, c := range counts {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestFingerprintChunkEqualIgnoresOrder(t *testing.T) {
+	a := `# Title
+
+See [docs](https://example.com/a) and [more](https://example.com/b).
+
+` + "```go\nfmt.Println(\"hi\")\n```"
+
+	b := `# Title
+
+See [more](https://example.com/b) and [docs](https://example.com/a).
+
+` + "```go\nfmt.Println(\"hi\")\n```"
+
+	if !fingerprintChunk(a).Equal(fingerprintChunk(b)) {
+		t.Fatalf("expected fingerprints to match when only link order changed")
+	}
+}
+
+func TestFingerprintChunkDetectsCodeBlockChange(t *testing.T) {
+	original := "```go\nfmt.Println(\"hi\")\n```"
+	mutated := "```go\nfmt.Println(\"bye\")\n```"
+
+	if fingerprintChunk(original).Equal(fingerprintChunk(mutated)) {
+		t.Fatalf("expected fingerprints to differ when code block contents changed")
+	}
+}
+
+func TestFingerprintChunkDetectsDroppedLink(t *testing.T) {
+	original := "See [docs](https://example.com/a)."
+	mutated := "See docs."
+
+	if fingerprintChunk(original).Equal(fingerprintChunk(mutated)) {
+		t.Fatalf("expected fingerprints to differ when a link URL was dropped")
+	}
+}
+
+func TestFingerprintChunkDetectsRenamedHeadingAnchor(t *testing.T) {
+	original := "## Getting Started"
+	mutated := "## Getting Started Now"
+
+	if fingerprintChunk(original).Equal(fingerprintChunk(mutated)) {
+		t.Fatalf("expected fingerprints to differ when a heading anchor changed")
+	}
+}
+
+func TestStringMultisetEqual(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{"both empty", nil, nil, true},
+		{"same order", []string{"x", "y"}, []string{"x", "y"}, true},
+		{"different order", []string{"x", "y"}, []string{"y", "x"}, true},
+		{"different lengths", []string{"x"}, []string{"x", "y"}, false},
+		{"different contents", []string{"x", "y"}, []string{"x", "z"}, false},
+		{"duplicate counts differ", []string{"x", "x"}, []string{"x", "y"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stringMultisetEqual(tc.a, tc.b); got != tc.want {
+				t.Errorf("stringMultisetEqual(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChunkMarkdownSplitsOnTopLevelHeadings(t *testing.T) {
+	content := "# One\nbody one\n# Two\nbody two\n"
+
+	chunks := chunkMarkdown(content)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+	if chunks[0].Content != "# One\nbody one" {
+		t.Errorf("unexpected first chunk: %q", chunks[0].Content)
+	}
+	if chunks[1].Content != "# Two\nbody two\n" {
+		t.Errorf("unexpected second chunk: %q", chunks[1].Content)
+	}
+}
+
+func TestChunkMarkdownIgnoresHeadingInsideFence(t *testing.T) {
+	content := "# One\n```\n# not a heading\n```\n# Two\nbody\n"
+
+	chunks := chunkMarkdown(content)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/term"
+)
+
+// renderDiff prints a unified diff between original and proposed for path,
+// colorized when stdout is a TTY. Used by -dry-run/-diff so users can see
+// what fixmd would change without it ever touching the file.
+func renderDiff(path, original, proposed string) {
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(original),
+		B:        difflib.SplitLines(proposed),
+		FromFile: path,
+		ToFile:   path + " (formatted)",
+		Context:  3,
+	}
+
+	text, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		printError("Error computing diff for %s: %v", path, err)
+		return
+	}
+
+	if text == "" {
+		return
+	}
+
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		printColorizedDiff(text)
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func printColorizedDiff(text string) {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+			fmt.Println(line)
+		case strings.HasPrefix(line, "+"):
+			fmt.Printf("\033[32m%s\033[0m\n", line)
+		case strings.HasPrefix(line, "-"):
+			fmt.Printf("\033[31m%s\033[0m\n", line)
+		case strings.HasPrefix(line, "@@"):
+			fmt.Printf("\033[36m%s\033[0m\n", line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
@@ -1,14 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"math"
-	"math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,125 +14,20 @@ import (
 )
 
 const (
-	BackupDirName  = "backup"
-	SystemPrompt   = "You are an API for formatting and fixing spelling mistakes in a markdown file passed to you. Your two main focuses are DO NOT CHANGE the actual content or meaning of the file whatsoever, only rectify the grammer and make it beautifully well formatted in markdown, utilising all markdown tools. Nothing more. Ensure your response is PURELY the file, as its being used directly in the program. Dont say here you go: or anything, and dont embed in code blocks."
-	MaxRetries     = 5
-	InitialBackoff = 1 * time.Second
-	MaxBackoff     = 30 * time.Second
-	MaxConcurrent  = 3
-
-	// Default values for environment variables
-	DefaultGeminiAPIURL = "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent"
-	EnvFile             = ".env"
-)
+	BackupDirName = "backup"
+	MaxConcurrent = 3
 
-var (
-	// Environment variables
-	GeminiAPIKey string
-	GeminiAPIURL string
+	EnvFile = ".env"
 )
 
-type GeminiRequest struct {
-	Contents []Content `json:"contents"`
-}
-
-type Content struct {
-	Role  string `json:"role,omitempty"`
-	Parts []Part `json:"parts"`
-}
-
-type Part struct {
-	Text string `json:"text"`
-}
-
-type GeminiResponse struct {
-	Candidates []Candidate `json:"candidates"`
-}
-
-type Candidate struct {
-	Content Content `json:"content"`
-}
-
 // FileToProcess contains the info of a file to be processed
 type FileToProcess struct {
-	Path       string
-	Content    []byte
-	BackupPath string
-}
-
-// StatusBar represents a console status bar for processing
-type StatusBar struct {
-	mu          sync.Mutex
-	total       int
-	processed   int
-	success     int
-	failed      int
-	lastUpdated time.Time
-	startTime   time.Time
-}
-
-func NewStatusBar(total int) *StatusBar {
-	return &StatusBar{
-		total:     total,
-		startTime: time.Now(),
-	}
-}
-
-func (s *StatusBar) IncrementSuccess() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.processed++
-	s.success++
-	s.lastUpdated = time.Now()
-	s.update()
-}
-
-func (s *StatusBar) IncrementFailed() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.processed++
-	s.failed++
-	s.lastUpdated = time.Now()
-	s.update()
-}
-
-func (s *StatusBar) update() {
-	elapsed := time.Since(s.startTime).Seconds()
-	percentage := float64(s.processed) / float64(s.total) * 100
-
-	fmt.Printf("\r[%s] Processing: %d/%d (%.1f%%) | Success: %d | Failed: %d | Elapsed: %.1fs",
-		getProgressBar(percentage),
-		s.processed, s.total,
-		percentage,
-		s.success, s.failed,
-		elapsed)
-}
-
-func (s *StatusBar) Finish() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	elapsed := time.Since(s.startTime).Seconds()
-	fmt.Printf("\r[%s] Completed: %d/%d (100%%) | Success: %d | Failed: %d | Elapsed: %.1fs\n\n",
-		getProgressBar(100),
-		s.total, s.total,
-		s.success, s.failed,
-		elapsed)
-}
-
-func getProgressBar(percentage float64) string {
-	width := 20
-	completed := int(percentage / 100 * float64(width))
-
-	bar := ""
-	for i := 0; i < width; i++ {
-		if i < completed {
-			bar += "="
-		} else {
-			bar += " "
-		}
-	}
-
-	return bar
+	Path    string
+	Content []byte
+	// RelPath is the file's path relative to the working directory,
+	// used both as its name inside a backup archive and as the
+	// loose .bak path when -backup-format=dir.
+	RelPath string
 }
 
 // ApiSemaphore controls concurrent access to the API
@@ -166,37 +56,47 @@ func init() {
 		fmt.Printf("Warning: .env file not found or couldn't be loaded: %v\n", err)
 		fmt.Println("Will check for environment variables directly or use defaults.")
 	}
-
-	// Get API key from environment variable
-	GeminiAPIKey = os.Getenv("GEMINI_API_KEY")
-	if GeminiAPIKey == "" {
-		fmt.Println("GEMINI_API_KEY not found in environment variables or .env file.")
-		fmt.Println("Please set it in .env file or as an environment variable.")
-		os.Exit(1)
-	}
-
-	// Get API URL from environment variable or use default
-	GeminiAPIURL = os.Getenv("GEMINI_API_URL")
-	if GeminiAPIURL == "" {
-		GeminiAPIURL = DefaultGeminiAPIURL
-		fmt.Println("GEMINI_API_URL not found, using default URL.")
-	}
 }
 
 func main() {
 	// Parse command line flags
 	recursive := flag.Bool("r", false, "Process directories recursively")
+	providerFlag := flag.String("provider", "", "LLM provider to use: gemini, openai, anthropic, ollama (default: $LLM_PROVIDER or gemini)")
+	backupFormat := flag.String("backup-format", DefaultBackupFormat, "Backup format: tar.zst, tar.gz, zip, or dir (loose files)")
+	restoreArchive := flag.String("restore", "", "Restore a backup archive over the working tree and exit")
+	dryRunFlag := flag.Bool("dry-run", false, "Show a diff of proposed changes without writing any files")
+	diffFlag := flag.Bool("diff", false, "Alias for -dry-run")
+	failOnChange := flag.Bool("fail-on-change", false, "Exit non-zero if any file would change (implies -dry-run; for pre-commit/CI use)")
+	noCache := flag.Bool("no-cache", false, "Disable the content-addressed cache")
+	refreshCache := flag.Bool("refresh-cache", false, "Ignore cached output and reprocess every file, updating the cache")
 	flag.Parse()
 
+	dryRun := *dryRunFlag || *diffFlag || *failOnChange
+
+	if *restoreArchive != "" {
+		if err := restoreBackup(*restoreArchive); err != nil {
+			printError("Error restoring backup: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println("Restore complete!")
+		return
+	}
+
 	// Check if we have enough arguments
 	args := flag.Args()
 	if len(args) < 1 {
-		fmt.Println("Usage: ./fixmd [-r] <filename.md or directory>")
+		fmt.Println("Usage: ./fixmd [-r] [-provider name] [-backup-format format] <filename.md or directory>")
 		os.Exit(1)
 	}
 
 	path := args[0]
 
+	provider, err := NewProviderFromEnv(*providerFlag)
+	if err != nil {
+		printError("Error configuring LLM provider: %v", err)
+		os.Exit(1)
+	}
+
 	// Get the current working directory
 	workDir, err := os.Getwd()
 	if err != nil {
@@ -207,8 +107,14 @@ func main() {
 	// Create the backup directory in the current working directory
 	backupDir := filepath.Join(workDir, BackupDirName)
 
-	// Initialize random seed for jitter in backoff
-	rand.Seed(time.Now().UnixNano())
+	var cache *Cache
+	if !*noCache {
+		cache, err = LoadCache(filepath.Join(workDir, DefaultCacheFile), *refreshCache)
+		if err != nil {
+			printError("Error loading cache: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	// Check if path is a file or directory
 	fileInfo, err := os.Stat(path)
@@ -223,7 +129,7 @@ func main() {
 	if fileInfo.IsDir() {
 		// Process directory
 		fmt.Printf("Collecting markdown files from directory: %s\n", path)
-		filesToProcess, err = collectFilesFromDir(path, backupDir, *recursive)
+		filesToProcess, err = collectFilesFromDir(path, *recursive)
 		if err != nil {
 			printError("Error collecting files: %v", err)
 			os.Exit(1)
@@ -236,7 +142,7 @@ func main() {
 			os.Exit(0)
 		}
 
-		fileToProcess, err := prepareFileForProcessing(path, backupDir)
+		fileToProcess, err := prepareFileForProcessing(path)
 		if err != nil {
 			printError("Error preparing file: %v", err)
 			os.Exit(1)
@@ -249,50 +155,86 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Backup all files first
-	fmt.Println("\n=== Creating Backups ===")
-	if err := backupAllFiles(filesToProcess); err != nil {
-		printError("Error during backup phase: %v", err)
-		os.Exit(1)
+	// Backup all files first - skipped in dry-run mode since nothing is
+	// ever written, so there's nothing to roll back.
+	if !dryRun {
+		fmt.Println("\n=== Creating Backups ===")
+		if err := backupAllFiles(filesToProcess, backupDir, *backupFormat); err != nil {
+			printError("Error during backup phase: %v", err)
+			os.Exit(1)
+		}
 	}
 
 	// Process all files only after successful backup
 	fmt.Println("\n=== Processing Files ===")
 
-	// Setup semaphore for API rate limiting
+	// Setup semaphore for API rate limiting. Concurrency is now enforced at
+	// chunk granularity (see formatMarkdown) rather than per file, so a
+	// large file's chunks share the same budget as everyone else's.
 	apiSemaphore := NewApiSemaphore(MaxConcurrent)
 
-	// Setup status bar
-	statusBar := NewStatusBar(len(filesToProcess))
+	// Setup the status UI and have it take over stdout so stray prints
+	// from elsewhere in the program surface above the status block instead
+	// of corrupting it. Skipped entirely in dry-run mode: renderDiff wants
+	// to colorize against the real stdout's TTY state and print a clean
+	// multi-line diff, neither of which work if stdout is the UI's pipe and
+	// every diff line gets wrapped in a "[info] " Message event.
+	var ui *UI
+	var stdio *stdioWrapper
+	if !dryRun {
+		ui = NewUI(len(filesToProcess))
+		retryNotifier = ui.Retry
+		stdio, err = wrapStdio(ui)
+		if err != nil {
+			printError("Error setting up status UI: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	// Setup wait group for all processing
 	var wg sync.WaitGroup
+	var changedMu sync.Mutex
+	anyChanged := false
 
 	for _, fileInfo := range filesToProcess {
 		wg.Add(1)
 		go func(fi FileToProcess) {
 			defer wg.Done()
-			// Acquire semaphore to limit concurrent API calls
-			apiSemaphore.Acquire()
-			defer apiSemaphore.Release()
-
-			success := processFileContent(fi)
-			if success {
-				statusBar.IncrementSuccess()
-			} else {
-				statusBar.IncrementFailed()
+
+			ui.FileStarted(fi.Path)
+			start := time.Now()
+			ctx := context.WithValue(context.Background(), filePathContextKey, fi.Path)
+			success, changed := processFileContent(ctx, provider, apiSemaphore, ui, cache, fi, dryRun)
+			ui.FileFinished(fi.Path, success, len(fi.Content), time.Since(start))
+
+			if changed {
+				changedMu.Lock()
+				anyChanged = true
+				changedMu.Unlock()
 			}
 		}(fileInfo)
 	}
 
 	wg.Wait()
-	statusBar.Finish()
+	stdio.Close()
+	ui.Close()
+
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			printError("Error saving cache: %v", err)
+		}
+	}
 
 	// Print summary
 	fmt.Println("Processing complete!")
+
+	if *failOnChange && anyChanged {
+		fmt.Println("fixmd: one or more files would change")
+		os.Exit(1)
+	}
 }
 
-func collectFilesFromDir(dirPath string, backupBaseDir string, recursive bool) ([]FileToProcess, error) {
+func collectFilesFromDir(dirPath string, recursive bool) ([]FileToProcess, error) {
 	var filesToProcess []FileToProcess
 
 	// Function to handle each file/directory
@@ -315,7 +257,7 @@ func collectFilesFromDir(dirPath string, backupBaseDir string, recursive bool) (
 			return nil
 		}
 
-		fileToProcess, err := prepareFileForProcessing(path, backupBaseDir)
+		fileToProcess, err := prepareFileForProcessing(path)
 		if err != nil {
 			return err
 		}
@@ -332,25 +274,38 @@ func collectFilesFromDir(dirPath string, backupBaseDir string, recursive bool) (
 	return filesToProcess, nil
 }
 
-func prepareFileForProcessing(filePath string, backupBaseDir string) (FileToProcess, error) {
+func prepareFileForProcessing(filePath string) (FileToProcess, error) {
 	// Read the file content
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return FileToProcess{}, fmt.Errorf("error reading file: %v", err)
 	}
 
-	// Get the relative path to maintain directory structure in backup
+	relPath, err := relativeToWorkDir(filePath)
+	if err != nil {
+		return FileToProcess{}, err
+	}
+
+	return FileToProcess{
+		Path:    filePath,
+		Content: content,
+		RelPath: relPath,
+	}, nil
+}
+
+// relativeToWorkDir returns filePath's path relative to the current working
+// directory, falling back to a flattened absolute path (with separators
+// replaced by underscores) for files that live outside of it.
+func relativeToWorkDir(filePath string) (string, error) {
 	absFilePath, err := filepath.Abs(filePath)
 	if err != nil {
-		return FileToProcess{}, fmt.Errorf("error getting absolute path: %v", err)
+		return "", fmt.Errorf("error getting absolute path: %v", err)
 	}
 
-	// Extract the directory structure to recreate it in the backup
 	fileDir := filepath.Dir(absFilePath)
 	workDir, _ := os.Getwd()
 	absWorkDir, _ := filepath.Abs(workDir)
 
-	// Get the relative path from the working directory
 	var relPath string
 	if fileDir == absWorkDir {
 		// File is in the current directory
@@ -370,141 +325,59 @@ func prepareFileForProcessing(filePath string, backupBaseDir string) (FileToProc
 		}
 	}
 
-	// Create backup file path
-	backupFilePath := filepath.Join(backupBaseDir, relPath+".bak")
-
-	return FileToProcess{
-		Path:       filePath,
-		Content:    content,
-		BackupPath: backupFilePath,
-	}, nil
+	return relPath, nil
 }
 
-func backupAllFiles(filesToProcess []FileToProcess) error {
-	for i, fileInfo := range filesToProcess {
-		// Create the backup directory structure
-		backupDirPath := filepath.Dir(fileInfo.BackupPath)
-		if err := os.MkdirAll(backupDirPath, 0755); err != nil {
-			return fmt.Errorf("error creating backup directory structure for %s: %v", fileInfo.Path, err)
+// processFileContent runs fileInfo through provider, one heading-aware
+// chunk at a time (see formatMarkdown). When dryRun is true, the file is
+// never written: instead a unified diff against the proposed content is
+// printed (if any) and changed reports whether it would have differed, so
+// -fail-on-change can drive a CI exit code. When cache is non-nil and the
+// file's current content already hashes to its own cached output, the API
+// call is skipped entirely - see Cache for why that's the right check.
+func processFileContent(ctx context.Context, provider Provider, sem *ApiSemaphore, ui *UI, cache *Cache, fileInfo FileToProcess, dryRun bool) (success bool, changed bool) {
+	original := string(fileInfo.Content)
+
+	if cache != nil {
+		key := cache.Key(provider.Identity(), PromptVersion, original)
+		if cached, ok := cache.Get(key); ok && cached == original {
+			if ui != nil {
+				ui.Message("cache", "%s: cache hit, already up to date", fileInfo.Path)
+			}
+			return true, false
 		}
-
-		// Write the backup file
-		if err := os.WriteFile(fileInfo.BackupPath, fileInfo.Content, 0644); err != nil {
-			return fmt.Errorf("error creating backup file for %s: %v", fileInfo.Path, err)
+		if ui != nil {
+			ui.Message("cache", "%s: cache miss", fileInfo.Path)
 		}
-
-		fmt.Printf("\r[%d/%d] Backup created: %s", i+1, len(filesToProcess), fileInfo.Path)
 	}
 
-	fmt.Println("\nAll files successfully backed up.")
-	return nil
-}
-
-func processFileContent(fileInfo FileToProcess) bool {
-	// Process the content with Gemini API
-	processedContent, err := processWithGeminiRetry(string(fileInfo.Content))
+	processedContent, err := formatMarkdown(ctx, provider, sem, ui, fileInfo.Path, original)
 	if err != nil {
 		printError("Error processing file %s: %v", fileInfo.Path, err)
-		return false
+		return false, false
 	}
 
-	// Write the processed content back to the original file
-	if err := os.WriteFile(fileInfo.Path, []byte(processedContent), 0644); err != nil {
-		printError("Error writing to file %s: %v", fileInfo.Path, err)
-		return false
+	if cache != nil {
+		cache.Set(cache.Key(provider.Identity(), PromptVersion, original), processedContent)
+		cache.Set(cache.Key(provider.Identity(), PromptVersion, processedContent), processedContent)
 	}
 
-	return true
-}
-
-func processWithGeminiRetry(content string) (string, error) {
-	var lastErr error
-
-	// Implement exponential backoff with jitter
-	for attempt := 0; attempt < MaxRetries; attempt++ {
-		result, err := processWithGemini(content)
-		if err == nil {
-			return result, nil
-		}
-
-		lastErr = err
+	changed = processedContent != original
 
-		// Calculate backoff duration with jitter
-		backoffSeconds := float64(InitialBackoff.Seconds()) * math.Pow(2, float64(attempt))
-		if backoffSeconds > MaxBackoff.Seconds() {
-			backoffSeconds = MaxBackoff.Seconds()
+	if dryRun {
+		if changed {
+			renderDiff(fileInfo.Path, original, processedContent)
 		}
-
-		// Add jitter (Â±20%)
-		jitter := rand.Float64()*0.4 - 0.2 // -20% to +20%
-		backoffWithJitter := time.Duration((backoffSeconds * (1 + jitter)) * float64(time.Second))
-
-		// Sleep before retry
-		time.Sleep(backoffWithJitter)
-	}
-
-	return "", fmt.Errorf("max retries exceeded: %v", lastErr)
-}
-
-func processWithGemini(content string) (string, error) {
-	// Prepare the request
-	requestBody := GeminiRequest{
-		Contents: []Content{
-			{
-				Role: "user",
-				Parts: []Part{
-					{Text: SystemPrompt},
-					{Text: content},
-				},
-			},
-		},
-	}
-
-	requestJSON, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %v", err)
-	}
-
-	// Create the request
-	apiURL := fmt.Sprintf("%s?key=%s", GeminiAPIURL, GeminiAPIKey)
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestJSON))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
+		return true, changed
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// Send the request
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Read the response
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API returned error status: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	// Parse the response
-	var geminiResp GeminiResponse
-	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
-		return "", fmt.Errorf("error unmarshaling response: %v", err)
-	}
-
-	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no valid response from API")
+	// Write the processed content back to the original file
+	if err := os.WriteFile(fileInfo.Path, []byte(processedContent), 0644); err != nil {
+		printError("Error writing to file %s: %v", fileInfo.Path, err)
+		return false, changed
 	}
 
-	// Return the processed content
-	return geminiResp.Candidates[0].Content.Parts[0].Text, nil
+	return true, changed
 }
 
 func printError(format string, args ...interface{}) {
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	MaxRetries     = 5
+	InitialBackoff = 1 * time.Second
+	MaxBackoff     = 30 * time.Second
+
+	DefaultGeminiAPIURL    = "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent"
+	DefaultGeminiModel     = "gemini-2.0-flash"
+	DefaultOpenAIAPIURL    = "https://api.openai.com/v1/chat/completions"
+	DefaultOpenAIModel     = "gpt-4o-mini"
+	DefaultAnthropicAPIURL = "https://api.anthropic.com/v1/messages"
+	DefaultAnthropicModel  = "claude-3-5-sonnet-latest"
+	DefaultOllamaAPIURL    = "http://localhost:11434/api/generate"
+	DefaultOllamaModel     = "llama3"
+
+	AnthropicAPIVersion = "2023-06-01"
+
+	// PromptVersion is folded into the cache key so a change to any
+	// provider's system prompt invalidates previously cached output.
+	PromptVersion = "v1"
+)
+
+// Provider formats a markdown document through an LLM and returns the
+// corrected content. Implementations must be safe for concurrent use.
+type Provider interface {
+	Format(ctx context.Context, content string) (string, error)
+	// Identity uniquely identifies the provider+model combination in use,
+	// e.g. "gemini:gemini-2.0-flash". Used as part of the cache key so
+	// switching models doesn't serve stale cached output.
+	Identity() string
+}
+
+// promptFor returns the system prompt tailored to how a given provider
+// expects instructions to be delivered (a bare text part, a chat "system"
+// message, an API-level system parameter, etc). The underlying instruction
+// is the same everywhere: fix spelling/formatting, never change meaning.
+func promptFor(style string) string {
+	base := "You are an API for formatting and fixing spelling mistakes in a markdown file passed to you. Your two main focuses are DO NOT CHANGE the actual content or meaning of the file whatsoever, only rectify the grammer and make it beautifully well formatted in markdown, utilising all markdown tools. Nothing more. Ensure your response is PURELY the file, as its being used directly in the program. Dont say here you go: or anything, and dont embed in code blocks."
+
+	switch style {
+	case "chat":
+		// Delivered as a dedicated system message ahead of the user turn, so it
+		// can be a little more direct about the response format.
+		return base + " Respond with plain markdown only, never wrapped in a code fence."
+	default:
+		return base
+	}
+}
+
+// NewProviderFromEnv resolves the provider to use from (in priority order)
+// the -provider flag, the LLM_PROVIDER env var, and finally the Gemini
+// default preserved for backwards compatibility. Credentials and model are
+// resolved the same way via LLM_API_KEY/LLM_MODEL with provider-specific
+// env vars as a fallback.
+func NewProviderFromEnv(flagValue string) (Provider, error) {
+	name := flagValue
+	if name == "" {
+		name = os.Getenv("LLM_PROVIDER")
+	}
+	if name == "" {
+		name = "gemini"
+	}
+
+	model := os.Getenv("LLM_MODEL")
+	apiKey := os.Getenv("LLM_API_KEY")
+
+	var inner Provider
+
+	switch name {
+	case "gemini":
+		if apiKey == "" {
+			apiKey = os.Getenv("GEMINI_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY (or LLM_API_KEY) not found in environment variables or .env file")
+		}
+		if model == "" {
+			model = DefaultGeminiModel
+		}
+		apiURL := os.Getenv("GEMINI_API_URL")
+		if apiURL == "" {
+			apiURL = DefaultGeminiAPIURL
+		}
+		inner = &GeminiProvider{APIKey: apiKey, APIURL: apiURL, Model: model}
+
+	case "openai":
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENAI_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY (or LLM_API_KEY) not found in environment variables or .env file")
+		}
+		if model == "" {
+			model = DefaultOpenAIModel
+		}
+		apiURL := os.Getenv("OPENAI_API_URL")
+		if apiURL == "" {
+			apiURL = DefaultOpenAIAPIURL
+		}
+		inner = &OpenAIProvider{APIKey: apiKey, APIURL: apiURL, Model: model}
+
+	case "anthropic":
+		if apiKey == "" {
+			apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY (or LLM_API_KEY) not found in environment variables or .env file")
+		}
+		if model == "" {
+			model = DefaultAnthropicModel
+		}
+		apiURL := os.Getenv("ANTHROPIC_API_URL")
+		if apiURL == "" {
+			apiURL = DefaultAnthropicAPIURL
+		}
+		inner = &AnthropicProvider{APIKey: apiKey, APIURL: apiURL, Model: model}
+
+	case "ollama":
+		if model == "" {
+			model = DefaultOllamaModel
+		}
+		apiURL := os.Getenv("OLLAMA_API_URL")
+		if apiURL == "" {
+			apiURL = DefaultOllamaAPIURL
+		}
+		inner = &OllamaProvider{APIURL: apiURL, Model: model}
+
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (expected gemini, openai, anthropic, or ollama)", name)
+	}
+
+	return newRetryingProvider(inner), nil
+}
+
+// filePathContextKeyType keys the file path stashed in a Format ctx so
+// retryingProvider can report which file is being retried.
+type filePathContextKeyType struct{}
+
+var filePathContextKey = filePathContextKeyType{}
+
+// retryNotifier, when set, is invoked before each backoff sleep so the
+// status UI can surface retry attempts per file. It's a package-level hook
+// rather than a constructor argument so Provider's signature stays the
+// same for callers (tests, future commands) that don't need a UI.
+var retryNotifier func(path string, attempt int, backoff time.Duration)
+
+// retryingProvider wraps a Provider with the exponential backoff + jitter
+// retry policy previously hardcoded into processWithGeminiRetry, so every
+// provider benefits from the same resilience against transient API errors.
+type retryingProvider struct {
+	inner Provider
+}
+
+func newRetryingProvider(inner Provider) *retryingProvider {
+	return &retryingProvider{inner: inner}
+}
+
+func (r *retryingProvider) Format(ctx context.Context, content string) (string, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < MaxRetries; attempt++ {
+		result, err := r.inner.Format(ctx, content)
+		if err == nil {
+			return result, nil
+		}
+
+		lastErr = err
+
+		backoffSeconds := float64(InitialBackoff.Seconds()) * math.Pow(2, float64(attempt))
+		if backoffSeconds > MaxBackoff.Seconds() {
+			backoffSeconds = MaxBackoff.Seconds()
+		}
+
+		// Add jitter (±20%)
+		jitter := rand.Float64()*0.4 - 0.2
+		backoffWithJitter := time.Duration((backoffSeconds * (1 + jitter)) * float64(time.Second))
+
+		if retryNotifier != nil {
+			path, _ := ctx.Value(filePathContextKey).(string)
+			retryNotifier(path, attempt+1, backoffWithJitter)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoffWithJitter):
+		}
+	}
+
+	return "", fmt.Errorf("max retries exceeded: %v", lastErr)
+}
+
+func (r *retryingProvider) Identity() string {
+	return r.inner.Identity()
+}
+
+// httpClient is shared by all providers below; 30s comfortably covers a
+// single markdown chunk without letting a stalled connection hang forever.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// --- Gemini ---
+
+type GeminiProvider struct {
+	APIKey string
+	APIURL string
+	Model  string
+}
+
+func (p *GeminiProvider) Identity() string {
+	return "gemini:" + p.Model
+}
+
+type geminiRequest struct {
+	Contents []geminiContent `json:"contents"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+}
+
+func (p *GeminiProvider) Format(ctx context.Context, content string) (string, error) {
+	requestBody := geminiRequest{
+		Contents: []geminiContent{
+			{
+				Role: "user",
+				Parts: []geminiPart{
+					{Text: promptFor("parts")},
+					{Text: content},
+				},
+			},
+		},
+	}
+
+	requestJSON, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	apiURL := fmt.Sprintf("%s?key=%s", p.APIURL, p.APIKey)
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	respBody, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed geminiResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("error unmarshaling response: %v", err)
+	}
+
+	if len(parsed.Candidates) == 0 || len(parsed.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("no valid response from API")
+	}
+
+	return parsed.Candidates[0].Content.Parts[0].Text, nil
+}
+
+// --- OpenAI (chat/completions) ---
+
+type OpenAIProvider struct {
+	APIKey string
+	APIURL string
+	Model  string
+}
+
+func (p *OpenAIProvider) Identity() string {
+	return "openai:" + p.Model
+}
+
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (p *OpenAIProvider) Format(ctx context.Context, content string) (string, error) {
+	requestBody := openAIRequest{
+		Model: p.Model,
+		Messages: []openAIMessage{
+			{Role: "system", Content: promptFor("chat")},
+			{Role: "user", Content: content},
+		},
+	}
+
+	requestJSON, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.APIURL, bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+
+	respBody, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed openAIResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("error unmarshaling response: %v", err)
+	}
+
+	if len(parsed.Choices) == 0 {
+		return "", fmt.Errorf("no valid response from API")
+	}
+
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// --- Anthropic (messages) ---
+
+type AnthropicProvider struct {
+	APIKey string
+	APIURL string
+	Model  string
+}
+
+func (p *AnthropicProvider) Identity() string {
+	return "anthropic:" + p.Model
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	System    string             `json:"system"`
+	MaxTokens int                `json:"max_tokens"`
+	Messages  []anthropicMessage `json:"messages"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+func (p *AnthropicProvider) Format(ctx context.Context, content string) (string, error) {
+	requestBody := anthropicRequest{
+		Model:     p.Model,
+		System:    promptFor("system"),
+		MaxTokens: 8192,
+		Messages: []anthropicMessage{
+			{Role: "user", Content: content},
+		},
+	}
+
+	requestJSON, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.APIURL, bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.APIKey)
+	req.Header.Set("anthropic-version", AnthropicAPIVersion)
+
+	respBody, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed anthropicResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("error unmarshaling response: %v", err)
+	}
+
+	if len(parsed.Content) == 0 {
+		return "", fmt.Errorf("no valid response from API")
+	}
+
+	return parsed.Content[0].Text, nil
+}
+
+// --- Ollama (local) ---
+
+type OllamaProvider struct {
+	APIURL string
+	Model  string
+}
+
+func (p *OllamaProvider) Identity() string {
+	return "ollama:" + p.Model
+}
+
+type ollamaRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	System string `json:"system"`
+	Stream bool   `json:"stream"`
+}
+
+type ollamaResponse struct {
+	Response string `json:"response"`
+}
+
+func (p *OllamaProvider) Format(ctx context.Context, content string) (string, error) {
+	requestBody := ollamaRequest{
+		Model:  p.Model,
+		Prompt: content,
+		System: promptFor("system"),
+		Stream: false,
+	}
+
+	requestJSON, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.APIURL, bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	respBody, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed ollamaResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("error unmarshaling response: %v", err)
+	}
+
+	if parsed.Response == "" {
+		return "", fmt.Errorf("no valid response from API")
+	}
+
+	return parsed.Response, nil
+}
+
+// doRequest sends req and returns the response body, translating non-2xx
+// responses into an error the same way all four providers used to inline.
+func doRequest(req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned error status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	return respBody, nil
+}
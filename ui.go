@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// UI owns stdout for the lifetime of a processing run. A single goroutine
+// consumes typed events off a channel and renders a stable multi-line
+// status block - an overall progress bar plus one line per active worker
+// showing its current file and retry state - using ANSI cursor movement,
+// modeled on restic's backup UI. When stdout isn't a TTY it falls back to
+// plain, append-only logging so redirected/piped output stays readable.
+type UI struct {
+	events chan uiEvent
+	done   chan struct{}
+	tty    bool
+
+	total     int
+	processed int
+	success   int
+	failed    int
+	startTime time.Time
+
+	active      map[string]*workerStatus
+	activeOrder []string
+	linesDrawn  int
+}
+
+type uiEventKind int
+
+const (
+	eventFileStarted uiEventKind = iota
+	eventFileFinished
+	eventRetry
+	eventMessage
+)
+
+type uiEvent struct {
+	kind     uiEventKind
+	path     string
+	ok       bool
+	bytes    int
+	duration time.Duration
+	attempt  int
+	backoff  time.Duration
+	level    string
+	text     string
+}
+
+type workerStatus struct {
+	path    string
+	attempt int
+	backoff time.Duration
+}
+
+// NewUI starts the rendering goroutine and returns a handle for reporting
+// events. total is the number of files that will be processed.
+func NewUI(total int) *UI {
+	u := &UI{
+		events:    make(chan uiEvent, 64),
+		done:      make(chan struct{}),
+		tty:       term.IsTerminal(int(os.Stdout.Fd())),
+		total:     total,
+		startTime: time.Now(),
+		active:    make(map[string]*workerStatus),
+	}
+	go u.run()
+	return u
+}
+
+// FileStarted reports that a worker has begun processing path. Safe to call
+// on a nil *UI (e.g. -dry-run, which runs without a status UI at all).
+func (u *UI) FileStarted(path string) {
+	if u == nil {
+		return
+	}
+	u.events <- uiEvent{kind: eventFileStarted, path: path}
+}
+
+// FileFinished reports that path finished processing, successfully or not.
+// Safe to call on a nil *UI.
+func (u *UI) FileFinished(path string, ok bool, bytes int, duration time.Duration) {
+	if u == nil {
+		return
+	}
+	u.events <- uiEvent{kind: eventFileFinished, path: path, ok: ok, bytes: bytes, duration: duration}
+}
+
+// Retry reports that path is about to be retried after backoff. Safe to
+// call on a nil *UI.
+func (u *UI) Retry(path string, attempt int, backoff time.Duration) {
+	if u == nil {
+		return
+	}
+	u.events <- uiEvent{kind: eventRetry, path: path, attempt: attempt, backoff: backoff}
+}
+
+// Message surfaces a one-off line (warning, info, cache stats, ...) above
+// the status block. Safe to call on a nil *UI, in which case it's a no-op -
+// callers that need dry-run output to appear regardless go through
+// fmt.Println instead (see renderDiff).
+func (u *UI) Message(level, format string, args ...interface{}) {
+	if u == nil {
+		return
+	}
+	u.events <- uiEvent{kind: eventMessage, level: level, text: fmt.Sprintf(format, args...)}
+}
+
+// Close drains remaining events, renders the final state, and stops the
+// rendering goroutine. Call once all workers have finished. Safe to call on
+// a nil *UI.
+func (u *UI) Close() {
+	if u == nil {
+		return
+	}
+	close(u.events)
+	<-u.done
+}
+
+func (u *UI) run() {
+	defer close(u.done)
+
+	for ev := range u.events {
+		u.apply(ev)
+	}
+
+	u.finalRender()
+}
+
+func (u *UI) apply(ev uiEvent) {
+	switch ev.kind {
+	case eventFileStarted:
+		u.active[ev.path] = &workerStatus{path: ev.path}
+		u.activeOrder = append(u.activeOrder, ev.path)
+		u.render()
+
+	case eventFileFinished:
+		delete(u.active, ev.path)
+		u.removeFromOrder(ev.path)
+		u.processed++
+		if ev.ok {
+			u.success++
+		} else {
+			u.failed++
+		}
+		u.render()
+
+	case eventRetry:
+		if w, ok := u.active[ev.path]; ok {
+			w.attempt = ev.attempt
+			w.backoff = ev.backoff
+		}
+		u.render()
+
+	case eventMessage:
+		u.printAbove(fmt.Sprintf("[%s] %s", ev.level, ev.text))
+	}
+}
+
+func (u *UI) removeFromOrder(path string) {
+	for i, p := range u.activeOrder {
+		if p == path {
+			u.activeOrder = append(u.activeOrder[:i], u.activeOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+// render redraws the status block in place. Non-TTY output instead prints
+// one line per event, append-only, so it stays sane when piped to a file
+// or CI log.
+func (u *UI) render() {
+	if !u.tty {
+		return
+	}
+
+	lines := u.statusLines()
+
+	// Move the cursor back to the top of the previously drawn block and
+	// clear each line before redrawing, restic-style.
+	if u.linesDrawn > 0 {
+		fmt.Printf("\033[%dA", u.linesDrawn)
+	}
+	for _, line := range lines {
+		fmt.Printf("\033[2K%s\n", line)
+	}
+	u.linesDrawn = len(lines)
+}
+
+// printAbove inserts a message line above the status block without
+// corrupting it: erase the block, print the message, redraw the block.
+func (u *UI) printAbove(msg string) {
+	if !u.tty {
+		fmt.Println(msg)
+		return
+	}
+
+	if u.linesDrawn > 0 {
+		fmt.Printf("\033[%dA", u.linesDrawn)
+		for i := 0; i < u.linesDrawn; i++ {
+			fmt.Print("\033[2K\n")
+		}
+		fmt.Printf("\033[%dA", u.linesDrawn)
+	}
+	fmt.Println(msg)
+	u.linesDrawn = 0
+	u.render()
+}
+
+func (u *UI) statusLines() []string {
+	elapsed := time.Since(u.startTime).Seconds()
+	percentage := 0.0
+	if u.total > 0 {
+		percentage = float64(u.processed) / float64(u.total) * 100
+	}
+
+	lines := []string{
+		fmt.Sprintf("[%s] %d/%d (%.1f%%) | Success: %d | Failed: %d | Elapsed: %.1fs",
+			getProgressBar(percentage), u.processed, u.total, percentage, u.success, u.failed, elapsed),
+	}
+
+	workers := append([]string(nil), u.activeOrder...)
+	sort.Strings(workers)
+	for _, path := range workers {
+		w := u.active[path]
+		if w.attempt > 0 {
+			lines = append(lines, fmt.Sprintf("  %s (retry %d, backoff %s)", path, w.attempt, w.backoff.Round(time.Millisecond)))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s", path))
+		}
+	}
+
+	return lines
+}
+
+func (u *UI) finalRender() {
+	if u.tty {
+		if u.linesDrawn > 0 {
+			fmt.Printf("\033[%dA", u.linesDrawn)
+		}
+		for i := 0; i < u.linesDrawn; i++ {
+			fmt.Print("\033[2K\n")
+		}
+		if u.linesDrawn > 0 {
+			fmt.Printf("\033[%dA", u.linesDrawn)
+		}
+	}
+
+	elapsed := time.Since(u.startTime).Seconds()
+	fmt.Printf("[%s] Completed: %d/%d (100%%) | Success: %d | Failed: %d | Elapsed: %.1fs\n\n",
+		getProgressBar(100), u.total, u.total, u.success, u.failed, elapsed)
+}
+
+func getProgressBar(percentage float64) string {
+	width := 20
+	completed := int(percentage / 100 * float64(width))
+
+	bar := ""
+	for i := 0; i < width; i++ {
+		if i < completed {
+			bar += "="
+		} else {
+			bar += " "
+		}
+	}
+
+	return bar
+}
+
+// stdioWrapper redirects the process's real stdout to a pipe for the
+// duration of a UI's lifetime and forwards anything written to it (stray
+// fmt.Print calls from code that doesn't know about the UI) to the UI as
+// Message events, so it appears above the status block instead of
+// corrupting it. It is a no-op when the UI isn't rendering to a TTY.
+type stdioWrapper struct {
+	ui   *UI
+	real *os.File
+	w    *os.File
+	done chan struct{}
+}
+
+// wrapStdio installs the wrapper, or returns nil if ui isn't attached to a
+// TTY, in which case plain fmt.Print output is left alone.
+func wrapStdio(ui *UI) (*stdioWrapper, error) {
+	if !ui.tty {
+		return nil, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdio pipe: %v", err)
+	}
+
+	sw := &stdioWrapper{ui: ui, real: os.Stdout, w: w, done: make(chan struct{})}
+	os.Stdout = w
+
+	go func() {
+		defer close(sw.done)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			ui.Message("info", "%s", scanner.Text())
+		}
+	}()
+
+	return sw, nil
+}
+
+// Close restores the real stdout and waits for the forwarder goroutine to
+// drain and forward whatever was left in the pipe before returning, so the
+// caller can safely call ui.Close() right after without racing a stray
+// ui.Message against the event channel being closed. Safe to call on a nil
+// *stdioWrapper.
+func (sw *stdioWrapper) Close() error {
+	if sw == nil {
+		return nil
+	}
+	os.Stdout = sw.real
+	err := sw.w.Close()
+	<-sw.done
+	return err
+}